@@ -0,0 +1,155 @@
+package kafka
+
+import "time"
+
+// MessageBatch is a group of messages from the same topic partition,
+// delivered together on PartitionConsumer.Batches once a BatchPolicy
+// decides the batch is ready.
+type MessageBatch struct {
+	Topic     string
+	Partition int
+
+	// Messages in the batch, in the order they were fetched.
+	Messages []Message
+
+	// HighWaterMarkOffset is the broker's high watermark as of the last
+	// message in the batch.
+	HighWaterMarkOffset int64
+}
+
+// FirstOffset returns the offset of the first message in the batch.
+func (b MessageBatch) FirstOffset() int64 { return b.Messages[0].Offset }
+
+// LastOffset returns the offset of the last message in the batch.
+func (b MessageBatch) LastOffset() int64 { return b.Messages[len(b.Messages)-1].Offset }
+
+// BatchPolicy decides when a growing batch of messages is ready to be
+// delivered. Implementations are consulted after every message is added to
+// the in-progress batch.
+type BatchPolicy interface {
+	// Ready reports whether the batch should be flushed now that it holds
+	// count messages totalling size bytes, with elapsed having passed since
+	// the first message was added to it.
+	Ready(count int, size int64, elapsed time.Duration) bool
+}
+
+// Count is a BatchPolicy that flushes once a batch holds n messages.
+type Count int
+
+// Ready implements the BatchPolicy interface.
+func (n Count) Ready(count int, size int64, elapsed time.Duration) bool { return count >= int(n) }
+
+// ByteSize is a BatchPolicy that flushes once a batch holds at least n bytes
+// of message keys and values combined.
+type ByteSize int64
+
+// Ready implements the BatchPolicy interface.
+func (n ByteSize) Ready(count int, size int64, elapsed time.Duration) bool { return size >= int64(n) }
+
+// Period is a BatchPolicy that flushes once a batch has been accumulating
+// for at least d, regardless of its size.
+type Period time.Duration
+
+// Ready implements the BatchPolicy interface.
+func (d Period) Ready(count int, size int64, elapsed time.Duration) bool {
+	return elapsed >= time.Duration(d)
+}
+
+// AnyOf combines multiple BatchPolicy values, flushing as soon as any one of
+// them is ready. This is how callers typically combine Count, ByteSize, and
+// Period triggers.
+type AnyOf []BatchPolicy
+
+// Ready implements the BatchPolicy interface.
+func (policies AnyOf) Ready(count int, size int64, elapsed time.Duration) bool {
+	for _, p := range policies {
+		if p.Ready(count, size, elapsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// OffsetTracker is notified when a MessageBatch has been processed by the
+// caller and its offset can be considered acknowledged.
+type OffsetTracker interface {
+	MarkOffset(topic string, partition int, offset int64, metadata string)
+}
+
+// OffsetTrackerFunc is an OffsetTracker implemented by a plain function.
+type OffsetTrackerFunc func(topic string, partition int, offset int64, metadata string)
+
+// MarkOffset implements the OffsetTracker interface.
+func (f OffsetTrackerFunc) MarkOffset(topic string, partition int, offset int64, metadata string) {
+	f(topic, partition, offset, metadata)
+}
+
+// batchBuilder accumulates messages for a single partition until policy
+// reports the batch is ready to be delivered.
+type batchBuilder struct {
+	topic     string
+	partition int
+	policy    BatchPolicy
+
+	messages  []Message
+	size      int64
+	startedAt time.Time
+	highWater int64
+}
+
+func newBatchBuilder(topic string, partition int, policy BatchPolicy) *batchBuilder {
+	return &batchBuilder{topic: topic, partition: partition, policy: policy}
+}
+
+// add appends msg to the in-progress batch and returns it, along with true,
+// if the batch is now ready to be flushed.
+func (b *batchBuilder) add(msg Message) (MessageBatch, bool) {
+	if len(b.messages) == 0 {
+		b.startedAt = time.Now()
+	}
+
+	b.messages = append(b.messages, msg)
+	b.size += int64(len(msg.Key) + len(msg.Value))
+	if msg.HighWaterMark > b.highWater {
+		b.highWater = msg.HighWaterMark
+	}
+
+	if !b.policy.Ready(len(b.messages), b.size, time.Since(b.startedAt)) {
+		return MessageBatch{}, false
+	}
+
+	batch := MessageBatch{
+		Topic:               b.topic,
+		Partition:           b.partition,
+		Messages:            b.messages,
+		HighWaterMarkOffset: b.highWater,
+	}
+
+	b.messages = nil
+	b.size = 0
+
+	return batch, true
+}
+
+// flush reports whether the in-progress batch is ready to be delivered
+// given how much time has elapsed since it was started, even though no new
+// message has been added since the last check. This lets a Period (or
+// AnyOf containing one) trigger on an idle partition instead of only being
+// evaluated when a new message arrives.
+func (b *batchBuilder) flush() (MessageBatch, bool) {
+	if len(b.messages) == 0 || !b.policy.Ready(len(b.messages), b.size, time.Since(b.startedAt)) {
+		return MessageBatch{}, false
+	}
+
+	batch := MessageBatch{
+		Topic:               b.topic,
+		Partition:           b.partition,
+		Messages:            b.messages,
+		HighWaterMarkOffset: b.highWater,
+	}
+
+	b.messages = nil
+	b.size = 0
+
+	return batch, true
+}
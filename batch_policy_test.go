@@ -0,0 +1,60 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchBuilderFlushesOnCount(t *testing.T) {
+	b := newBatchBuilder("topic-a", 0, Count(2))
+
+	if _, ready := b.add(Message{Offset: 0}); ready {
+		t.Fatalf("batch should not be ready after a single message")
+	}
+
+	batch, ready := b.add(Message{Offset: 1})
+	if !ready {
+		t.Fatalf("batch should be ready once it holds 2 messages")
+	}
+	if len(batch.Messages) != 2 || batch.FirstOffset() != 0 || batch.LastOffset() != 1 {
+		t.Fatalf("unexpected batch contents: %+v", batch)
+	}
+}
+
+func TestBatchBuilderFlushOnIdlePeriod(t *testing.T) {
+	b := newBatchBuilder("topic-a", 0, Period(10*time.Millisecond))
+	b.add(Message{Offset: 0})
+	time.Sleep(20 * time.Millisecond)
+
+	batch, ready := b.flush()
+	if !ready {
+		t.Fatalf("expected flush to report the idle batch as ready")
+	}
+	if len(batch.Messages) != 1 {
+		t.Fatalf("unexpected batch contents: %+v", batch)
+	}
+
+	if _, ready := b.flush(); ready {
+		t.Fatalf("flush should not report anything once the batch has been drained")
+	}
+}
+
+func TestAnyOfReadyOnFirstMatch(t *testing.T) {
+	policy := AnyOf{Count(100), Period(0)}
+	if !policy.Ready(1, 1, time.Millisecond) {
+		t.Fatalf("expected AnyOf to be ready once any one policy is")
+	}
+}
+
+func TestOffsetTrackerFunc(t *testing.T) {
+	var got []int64
+	tracker := OffsetTrackerFunc(func(topic string, partition int, offset int64, metadata string) {
+		got = append(got, offset)
+	})
+
+	tracker.MarkOffset("topic-a", 0, 7, "")
+
+	if len(got) != 1 || got[0] != 7 {
+		t.Fatalf("expected MarkOffset to be forwarded to the function, got %v", got)
+	}
+}
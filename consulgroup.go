@@ -0,0 +1,512 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// Scheme selects the strategy used by a ConsulGroup to distribute ownership
+// of a topic's partitions across the members of the group.
+type Scheme int
+
+const (
+	// Spread assigns partitions to members by splitting the partition space
+	// into contiguous, evenly sized ranges. It is the default scheme.
+	Spread Scheme = iota
+	// Sticky assigns the same contiguous ranges as Spread. No record of past
+	// assignments is kept, so when membership changes partitions move
+	// between members exactly as they do under Spread.
+	Sticky
+	// RoundRobin distributes partitions to members one at a time, in
+	// round-robin order.
+	RoundRobin
+)
+
+// ConsulConfig configures a ConsulGroup.
+type ConsulConfig struct {
+	// Name of the consumer group. Group state is stored in Consul under the
+	// kafka/groups/<Name>/ prefix.
+	Name string
+
+	// Addr of the Consul HTTP API, e.g. "localhost:8500".
+	Addr string
+
+	// Scheme controls how partitions are assigned to members of the group.
+	//
+	// Defaults to Spread.
+	Scheme Scheme
+
+	// SessionTTL is the TTL of the Consul session used to hold partition
+	// locks. The session is renewed automatically at roughly SessionTTL/3.
+	//
+	// Defaults to 15s.
+	SessionTTL time.Duration
+}
+
+func (config *ConsulConfig) validate() error {
+	if config.Name == "" {
+		return fmt.Errorf("kafka.ConsulConfig: Name must not be empty")
+	}
+	if config.SessionTTL == 0 {
+		config.SessionTTL = 15 * time.Second
+	}
+	return nil
+}
+
+// ConsulGroup coordinates a group of consumers using Consul sessions and KV
+// locks instead of kafka's native group protocol. Each partition of a topic
+// is owned by at most one member at a time, and offsets are committed to
+// Consul KV rather than the __consumer_offsets topic, so the entire state of
+// the group lives outside of the kafka cluster.
+type ConsulGroup struct {
+	config ConsulConfig
+	client *consul.Client
+
+	mutex     sync.Mutex
+	sessionID string
+	owned     map[int]*consulLock
+	onRevoke  map[int]func()
+
+	once sync.Once
+	done chan struct{}
+}
+
+type consulLock struct {
+	key string
+}
+
+// NewConsulGroup creates a ConsulGroup backed by the Consul agent at
+// config.Addr, and starts the background heartbeat that keeps the group's
+// session alive for as long as the group is in use.
+func NewConsulGroup(config ConsulConfig) (*ConsulGroup, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	clientConfig := consul.DefaultConfig()
+	if config.Addr != "" {
+		clientConfig.Address = config.Addr
+	}
+
+	client, err := consul.NewClient(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("kafka.NewConsulGroup: %w", err)
+	}
+
+	sessionID, _, err := client.Session().Create(&consul.SessionEntry{
+		Name:      "kafka/groups/" + config.Name,
+		TTL:       config.SessionTTL.String(),
+		Behavior:  consul.SessionBehaviorRelease,
+		LockDelay: 0,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("kafka.NewConsulGroup: creating consul session: %w", err)
+	}
+
+	g := &ConsulGroup{
+		config:    config,
+		client:    client,
+		sessionID: sessionID,
+		owned:     make(map[int]*consulLock),
+		onRevoke:  make(map[int]func()),
+		done:      make(chan struct{}),
+	}
+
+	go g.heartbeat()
+
+	return g, nil
+}
+
+// heartbeat renews the group's Consul session until the group is closed, so
+// that the locks held on owned partitions are not released while the
+// process is alive.
+func (g *ConsulGroup) heartbeat() {
+	ticker := time.NewTicker(g.config.SessionTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+			if _, _, err := g.client.Session().Renew(g.sessionID, nil); err != nil {
+				// The session could not be renewed; any partitions we
+				// thought we owned may be reassigned by Consul once the TTL
+				// expires, so revoke them locally rather than risk two
+				// members believing they own the same partition.
+				g.revokeAll()
+			}
+		}
+	}
+}
+
+// partitionKey returns the Consul KV key used to lock ownership of a single
+// partition of topic.
+func (g *ConsulGroup) partitionKey(topic string, partition int) string {
+	return fmt.Sprintf("kafka/groups/%s/partitions/%s/%d", g.config.Name, topic, partition)
+}
+
+// memberKey returns the Consul KV key this member registers itself under,
+// used by other members to compute the size of the group for assignment
+// purposes.
+func (g *ConsulGroup) memberKey() string {
+	return fmt.Sprintf("kafka/groups/%s/members/%s", g.config.Name, g.sessionID)
+}
+
+// offsetKey returns the Consul KV key that stores the committed offset for
+// partition of topic.
+func (g *ConsulGroup) offsetKey(topic string, partition int) string {
+	return fmt.Sprintf("kafka/groups/%s/offsets/%s/%d", g.config.Name, topic, partition)
+}
+
+// MarkOffset commits offset (and an optional piece of caller-defined
+// metadata) for partition of topic to Consul KV, so that the group's
+// progress is durable without relying on kafka's __consumer_offsets topic.
+// It implements the OffsetTracker interface, so a ConsulGroup can be passed
+// directly as PartitionConsumerConfig.OffsetTracker. Errors committing the
+// offset are swallowed, matching OffsetTracker's signature; callers that
+// need to observe them should use CommitOffset instead.
+func (g *ConsulGroup) MarkOffset(topic string, partition int, offset int64, metadata string) {
+	g.CommitOffset(topic, partition, offset, metadata)
+}
+
+// CommitOffset commits offset (and an optional piece of caller-defined
+// metadata) for partition of topic to Consul KV.
+func (g *ConsulGroup) CommitOffset(topic string, partition int, offset int64, metadata string) error {
+	value := strconv.FormatInt(offset, 10) + "," + metadata
+	if _, err := g.client.KV().Put(&consul.KVPair{
+		Key:   g.offsetKey(topic, partition),
+		Value: []byte(value),
+	}, nil); err != nil {
+		return fmt.Errorf("kafka.(*ConsulGroup).CommitOffset: %w", err)
+	}
+	return nil
+}
+
+// CommittedOffset returns the last offset committed for partition of topic,
+// along with whatever metadata was committed alongside it. It returns
+// offset -1 if no offset has been committed yet.
+func (g *ConsulGroup) CommittedOffset(topic string, partition int) (offset int64, metadata string, err error) {
+	pair, _, err := g.client.KV().Get(g.offsetKey(topic, partition), nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("kafka.(*ConsulGroup).CommittedOffset: %w", err)
+	}
+	if pair == nil {
+		return -1, "", nil
+	}
+
+	value := string(pair.Value)
+	offsetPart, metadataPart, _ := strings.Cut(value, ",")
+
+	offset, err = strconv.ParseInt(offsetPart, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("kafka.(*ConsulGroup).CommittedOffset: invalid offset %q: %w", value, err)
+	}
+
+	return offset, metadataPart, nil
+}
+
+// members returns the session IDs of every member currently registered in
+// the group, including this one, sorted for deterministic assignment.
+func (g *ConsulGroup) members() ([]string, error) {
+	kv := g.client.KV()
+
+	if _, _, err := kv.Acquire(&consul.KVPair{
+		Key:     g.memberKey(),
+		Session: g.sessionID,
+	}, nil); err != nil {
+		return nil, err
+	}
+
+	pairs, _, err := kv.List(fmt.Sprintf("kafka/groups/%s/members/", g.config.Name), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		if pair.Session != "" {
+			ids = append(ids, pair.Session)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// assign computes the set of partition indexes, out of numPartitions total,
+// that this member should attempt to own given the current membership of
+// the group.
+func (g *ConsulGroup) assign(numPartitions int, members []string) []int {
+	index := sort.SearchStrings(members, g.sessionID)
+	count := len(members)
+	if count == 0 {
+		return nil
+	}
+
+	switch g.config.Scheme {
+	case RoundRobin:
+		var partitions []int
+		for p := 0; p < numPartitions; p++ {
+			if p%count == index {
+				partitions = append(partitions, p)
+			}
+		}
+		return partitions
+
+	case Sticky:
+		// Without a persisted assignment history, Sticky falls back to the
+		// same evenly sized ranges as Spread; partitions outside this
+		// member's range are released by reconcile, as under Spread.
+		fallthrough
+
+	default: // Spread
+		lo := (numPartitions * index) / count
+		hi := (numPartitions * (index + 1)) / count
+		partitions := make([]int, 0, hi-lo)
+		for p := lo; p < hi; p++ {
+			partitions = append(partitions, p)
+		}
+		return partitions
+	}
+}
+
+// acquire attempts to lock partition of topic for this member, returning
+// true if the lock was acquired (or already held by this member).
+func (g *ConsulGroup) acquire(topic string, partition int) (bool, error) {
+	g.mutex.Lock()
+	if _, ok := g.owned[partition]; ok {
+		g.mutex.Unlock()
+		return true, nil
+	}
+	g.mutex.Unlock()
+
+	key := g.partitionKey(topic, partition)
+	ok, _, err := g.client.KV().Acquire(&consul.KVPair{
+		Key:     key,
+		Session: g.sessionID,
+	}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		g.mutex.Lock()
+		g.owned[partition] = &consulLock{key: key}
+		g.mutex.Unlock()
+	}
+
+	return ok, nil
+}
+
+// release gives up ownership of partition, invoking and clearing any
+// revocation callback registered for it so that in-flight MessageIters
+// bound to that partition stop cleanly.
+func (g *ConsulGroup) release(partition int) {
+	g.mutex.Lock()
+	lock, ok := g.owned[partition]
+	onRevoke := g.onRevoke[partition]
+	delete(g.owned, partition)
+	delete(g.onRevoke, partition)
+	g.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	g.client.KV().Release(&consul.KVPair{
+		Key:     lock.key,
+		Session: g.sessionID,
+	}, nil)
+
+	if onRevoke != nil {
+		onRevoke()
+	}
+}
+
+// reconcile releases every partition this member currently holds a lock on
+// that is not in assigned, so that a fresh assign() result (a new member
+// joining, or one leaving) actually takes partitions away from members that
+// no longer own them under the configured Scheme, instead of leaving
+// ownership sticky forever.
+func (g *ConsulGroup) reconcile(assigned []int) {
+	want := make(map[int]bool, len(assigned))
+	for _, p := range assigned {
+		want[p] = true
+	}
+
+	g.mutex.Lock()
+	var stale []int
+	for p := range g.owned {
+		if !want[p] {
+			stale = append(stale, p)
+		}
+	}
+	g.mutex.Unlock()
+
+	for _, p := range stale {
+		g.release(p)
+	}
+}
+
+func (g *ConsulGroup) revokeAll() {
+	g.mutex.Lock()
+	partitions := make([]int, 0, len(g.owned))
+	for p := range g.owned {
+		partitions = append(partitions, p)
+	}
+	g.mutex.Unlock()
+
+	for _, p := range partitions {
+		g.release(p)
+	}
+}
+
+// OnRevoke registers a callback invoked when ownership of partition is
+// given up, either because the group rebalanced it away from this member or
+// because the member's session could not be renewed. The callback is
+// expected to stop any MessageIter reading from that partition.
+func (g *ConsulGroup) OnRevoke(partition int, fn func()) {
+	g.mutex.Lock()
+	g.onRevoke[partition] = fn
+	g.mutex.Unlock()
+}
+
+// NewReader returns a Reader over whichever partitions of config.Topic this
+// member currently owns. config.Partitions must be set to the total number
+// of partitions of the topic, so that the group can compute assignment
+// ranges; ownership is re-evaluated every time Read is called, so the set
+// of partitions a long-lived reader pulls from can grow or shrink as the
+// group rebalances.
+func (g *ConsulGroup) NewReader(config ReaderConfig) (*GroupReader, error) {
+	if config.Partitions <= 0 {
+		return nil, fmt.Errorf("kafka.(*ConsulGroup).NewReader: Partitions must be set to the topic's partition count")
+	}
+	return &GroupReader{group: g, config: config}, nil
+}
+
+// NewReaders returns a channel of Readers, one for each partition of
+// config.Topic that this member owns. A new Reader is sent on the channel
+// every time a rebalance grants this member ownership of an additional
+// partition; the channel is closed when the group is closed.
+func (g *ConsulGroup) NewReaders(config ReaderConfig) (<-chan *Reader, error) {
+	if config.Partitions <= 0 {
+		return nil, fmt.Errorf("kafka.(*ConsulGroup).NewReaders: Partitions must be set to the topic's partition count")
+	}
+
+	readers := make(chan *Reader)
+	sent := make(map[int]bool)
+
+	go func() {
+		defer close(readers)
+
+		ticker := time.NewTicker(g.config.SessionTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			members, err := g.members()
+			if err == nil {
+				assigned := g.assign(config.Partitions, members)
+				g.reconcile(assigned)
+
+				assignedSet := make(map[int]bool, len(assigned))
+				for _, p := range assigned {
+					assignedSet[p] = true
+				}
+				for p := range sent {
+					if !assignedSet[p] {
+						delete(sent, p)
+					}
+				}
+
+				for _, p := range assigned {
+					if sent[p] {
+						continue
+					}
+					ok, err := g.acquire(config.Topic, p)
+					if err != nil || !ok {
+						continue
+					}
+
+					partitionConfig := config
+					partitionConfig.Partition = p
+
+					select {
+					case readers <- NewReader(partitionConfig):
+						sent[p] = true
+					case <-g.done:
+						return
+					}
+				}
+			}
+
+			select {
+			case <-g.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return readers, nil
+}
+
+// GroupReader reads messages from every partition a ConsulGroup member
+// currently owns for a given topic, re-evaluating ownership each time Read
+// is called so that rebalances are picked up transparently.
+type GroupReader struct {
+	group  *ConsulGroup
+	config ReaderConfig
+}
+
+// Read returns a MessageIter over every partition of g's topic that the
+// owning ConsulGroup member currently holds a lock on. Partitions that
+// cannot be locked (because another member already owns them) are silently
+// skipped; the next call to Read will pick them up once they become
+// available. Partitions this member held a lock on that the refreshed
+// assignment no longer includes are released before Read returns.
+func (g *GroupReader) Read(ctx context.Context, offset Offset) MessageIter {
+	members, err := g.group.members()
+	if err != nil {
+		return NewMultiIter(nil)
+	}
+
+	assigned := g.group.assign(g.config.Partitions, members)
+	g.group.reconcile(assigned)
+
+	var iters []MessageIter
+
+	for _, p := range assigned {
+		ok, err := g.group.acquire(g.config.Topic, p)
+		if err != nil || !ok {
+			continue
+		}
+
+		partitionConfig := g.config
+		partitionConfig.Partition = p
+
+		iters = append(iters, NewReader(partitionConfig).Read(ctx, offset))
+	}
+
+	return NewMultiIter(iters)
+}
+
+// Close releases every partition lock held by this member, whatever its
+// topic, removes the member's registration, destroys its Consul session, and
+// stops its session heartbeat.
+func (g *ConsulGroup) Close() error {
+	g.once.Do(func() {
+		close(g.done)
+		g.revokeAll()
+		g.client.KV().Delete(g.memberKey(), nil)
+		g.client.Session().Destroy(g.sessionID, nil)
+	})
+	return nil
+}
@@ -2,6 +2,7 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"net"
@@ -22,6 +23,24 @@ type FetchRequest struct {
 	Partition int
 	Offset    int64
 
+	// CurrentLeaderEpoch is the epoch of the partition leader that the
+	// caller last observed. The broker uses it to fence the request against
+	// stale metadata: if the caller's view of the leader is behind or ahead
+	// of the broker's, FetchResponse.Error is set to FencedLeaderEpoch or
+	// UnknownLeaderEpoch so the caller can refresh its metadata instead of
+	// reading from a partition that underwent an unclean leader election.
+	//
+	// nil means no epoch is known and disables fencing, the same as setting
+	// -1 on the wire; this is also the behavior of requests that don't set
+	// this field. Epoch 0 is a real, common value (every partition starts at
+	// epoch 0 until its first leader change), so callers that want to fence
+	// against it must set CurrentLeaderEpoch explicitly rather than relying
+	// on the zero value of an int.
+	//
+	// This field requires the kafka broker to support the Fetch API in
+	// version 9 or above (otherwise the value is ignored).
+	CurrentLeaderEpoch *int32
+
 	// Size and time limits of the response returned by the broker.
 	MinBytes int64
 	MaxBytes int64
@@ -36,6 +55,13 @@ type FetchRequest struct {
 	IsolationLevel IsolationLevel
 }
 
+func (req *FetchRequest) currentLeaderEpoch() int32 {
+	if req.CurrentLeaderEpoch != nil {
+		return *req.CurrentLeaderEpoch
+	}
+	return -1
+}
+
 // FetchResponse represents a response from a kafka broker to a fetch request.
 type FetchResponse struct {
 	// The amount of time that the broker throttled the request.
@@ -57,11 +83,25 @@ type FetchResponse struct {
 	LastStableOffset int64
 	LogStartOffset   int64
 
+	// DivergingEpoch is set when the broker detects that the log diverged
+	// from what the caller's CurrentLeaderEpoch assumed, typically because
+	// of an unclean leader election. Callers implementing exactly-once
+	// semantics should truncate their local log to DivergingEpoch.EndOffset
+	// before resuming consumption.
+	//
+	// This field requires the kafka broker to support the Fetch API in
+	// version 12 or above (otherwise it is always the zero value).
+	DivergingEpoch DivergingEpoch
+
 	// An error that may have occured while attempting to fetch the records.
 	//
 	// The error contains both the kafka error code, and an error message
 	// returned by the kafka broker. Programs may use the standard errors.Is
 	// function to test the error against kafka error codes.
+	//
+	// FencedLeaderEpoch and UnknownLeaderEpoch indicate that the request's
+	// CurrentLeaderEpoch is stale; callers should refresh their metadata
+	// rather than retry the fetch as-is.
 	Error error
 
 	// The set of records returned in the response.
@@ -75,75 +115,75 @@ type FetchResponse struct {
 	Records RecordReader
 }
 
+// DivergingEpoch identifies the point at which a follower's or consumer's
+// view of a partition's log diverges from the leader's, as reported by
+// FetchResponse.DivergingEpoch.
+type DivergingEpoch struct {
+	// Epoch is the last leader epoch that the caller's log has in common
+	// with the broker's.
+	Epoch int32
+	// EndOffset is the offset at which the logs diverge; records at or
+	// after this offset must be truncated and re-fetched.
+	EndOffset int64
+}
+
 // Fetch sends a fetch request to a kafka broker and returns the response.
 //
+// Fetch is a convenience wrapper around FetchSession: it opens a one-shot
+// session for the single request and closes it again before returning, so
+// callers that only need to fetch a partition once don't have to manage a
+// FetchSession themselves.
+//
 // If the broker returned an invalid response with no topics, an error wrapping
 // protocol.ErrNoTopic is returned.
 //
 // If the broker returned an invalid response with no partitions, an error
 // wrapping ErrNoPartitions is returned.
 func (c *Client) Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
-	timeout := c.timeout(ctx, math.MaxInt64)
-	maxWait := req.maxWait()
-
-	if maxWait < timeout {
-		timeout = maxWait
-	}
-
-	m, err := c.roundTrip(ctx, req.Addr, &fetchAPI.Request{
-		ReplicaID:      -1,
-		MaxWaitTime:    milliseconds(timeout),
-		MinBytes:       int32(req.MinBytes),
-		MaxBytes:       int32(req.MaxBytes),
-		IsolationLevel: int8(req.IsolationLevel),
-		SessionID:      -1,
-		SessionEpoch:   -1,
-		Topics: []fetchAPI.RequestTopic{{
-			Topic: req.Topic,
-			Partitions: []fetchAPI.RequestPartition{{
-				Partition:          int32(req.Partition),
-				CurrentLeaderEpoch: -1,
-				FetchOffset:        req.Offset,
-				LogStartOffset:     -1,
-				PartitionMaxBytes:  int32(req.MaxBytes),
+	session := c.NewFetchSession(req.Addr)
+
+	res, err := session.Fetch(ctx, &FetchSessionRequest{
+		Requests: map[string][]FetchPartitionRequest{
+			req.Topic: {{
+				Partition:          req.Partition,
+				Offset:             req.Offset,
+				MaxBytes:           req.MaxBytes,
+				CurrentLeaderEpoch: req.CurrentLeaderEpoch,
 			}},
-		}},
+		},
+		MinBytes:       req.MinBytes,
+		MaxBytes:       req.MaxBytes,
+		MaxWait:        req.maxWait(),
+		IsolationLevel: req.IsolationLevel,
 	})
 
+	if closeErr := session.Close(ctx); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return nil, fmt.Errorf("kafka.(*Client).Fetch: %w", err)
 	}
 
-	res := m.(*fetchAPI.Response)
-	if len(res.Topics) == 0 {
+	partitions, ok := res.Responses[req.Topic]
+	if !ok {
 		return nil, fmt.Errorf("kafka.(*Client).Fetch: %w", protocol.ErrNoTopic)
 	}
-	topic := &res.Topics[0]
-	if len(topic.Partitions) == 0 {
+	if len(partitions) == 0 {
 		return nil, fmt.Errorf("kafka.(*Client).Fetch: %w", protocol.ErrNoPartition)
 	}
-	partition := &topic.Partitions[0]
+	partition := &partitions[0]
 
-	ret := &FetchResponse{
-		Throttle:         makeDuration(res.ThrottleTimeMs),
-		Topic:            topic.Topic,
-		Partition:        int(partition.Partition),
-		Error:            makeError(res.ErrorCode, ""),
+	return &FetchResponse{
+		Throttle:         res.Throttle,
+		Topic:            req.Topic,
+		Partition:        partition.Partition,
+		Error:            partition.Error,
 		HighWatermark:    partition.HighWatermark,
 		LastStableOffset: partition.LastStableOffset,
 		LogStartOffset:   partition.LogStartOffset,
-		Records:          partition.RecordSet.Records,
-	}
-
-	if partition.ErrorCode != 0 {
-		ret.Error = makeError(partition.ErrorCode, "")
-	}
-
-	if ret.Records == nil {
-		ret.Records = NewRecordReader()
-	}
-
-	return ret, nil
+		DivergingEpoch:   partition.DivergingEpoch,
+		Records:          partition.Records,
+	}, nil
 }
 
 func (req *FetchRequest) maxWait() time.Duration {
@@ -159,6 +199,18 @@ type FetchPartitionRequest struct {
 	Partition int
 	Offset    int64
 	MaxBytes  int64
+
+	// CurrentLeaderEpoch is the epoch of the partition leader that the
+	// caller last observed. See FetchRequest.CurrentLeaderEpoch for details,
+	// including why nil (not 0) must be used to mean "no epoch known".
+	CurrentLeaderEpoch *int32
+}
+
+func (req *FetchPartitionRequest) currentLeaderEpoch() int32 {
+	if req.CurrentLeaderEpoch != nil {
+		return *req.CurrentLeaderEpoch
+	}
+	return -1
 }
 
 // FetchPartitionResponse represents a response from fetching a partition offset
@@ -169,20 +221,24 @@ type FetchPartitionResponse struct {
 	HighWatermark    int64
 	LastStableOffset int64
 	LogStartOffset   int64
+	DivergingEpoch   DivergingEpoch
 	Records          RecordReader
 }
 
 // MultiFetchRequest represents a request sent to a kafka broker to fetch
 // records from multipl topic partition offsets.
 type MultiFetchRequest struct {
-	// Address of the kafka broker to send the request to.
+	// Address of a broker in the cluster, used to discover partition leaders
+	// and as the destination for any partition whose leader is not yet
+	// known.
 	Addr net.Addr
 
 	// Indexed by topic name, the lists of partition offsets to fetch records
 	// from.
 	//
-	// Note: currently the implementation is limited to fetching records from
-	// a single broker.
+	// Partitions may be spread across any number of brokers in the cluster;
+	// Client.MultiFetch resolves each partition's leader through Metadata
+	// and fans the request out accordingly.
 	Requests map[string][]FetchPartitionRequest
 
 	// Size and time limits of the response returned by the broker.
@@ -217,9 +273,75 @@ type MultiFetchResponse struct {
 }
 
 func (c *Client) MultiFetch(ctx context.Context, req *MultiFetchRequest) (*MultiFetchResponse, error) {
-	topics := make([]fetchAPI.RequestTopic, 0, 2*len(req.Requests))
+	if len(req.Requests) == 0 {
+		return nil, fmt.Errorf("kafka.(*Client).MultiFetch: %w", protocol.ErrNoTopic)
+	}
+
+	byLeader, err := c.groupMultiFetchByLeader(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("kafka.(*Client).MultiFetch: %w", err)
+	}
+
+	type brokerResult struct {
+		addr net.Addr
+		res  *MultiFetchResponse
+		err  error
+		reqs map[string][]FetchPartitionRequest
+	}
+
+	results := make(chan brokerResult, len(byLeader))
 
-	for topicName, partitions := range req.Requests {
+	for addr, topics := range byLeader {
+		addr, topics := addr, topics
+		go func() {
+			res, err := c.multiFetch(ctx, addr, req, topics)
+			results <- brokerResult{addr: addr, res: res, err: err, reqs: topics}
+		}()
+	}
+
+	ret := &MultiFetchResponse{
+		Responses: make(map[string][]FetchPartitionResponse),
+	}
+
+	for i := 0; i < len(byLeader); i++ {
+		r := <-results
+		if r.err == nil {
+			ret.Throttle += r.res.Throttle
+			if ret.Error == nil {
+				ret.Error = r.res.Error
+			}
+			for topic, partitions := range r.res.Responses {
+				ret.Responses[topic] = append(ret.Responses[topic], partitions...)
+			}
+			continue
+		}
+
+		// A whole-broker failure (e.g. the connection could not be
+		// established) is reported on every partition that had been routed
+		// to that broker, so that callers can still distinguish it from a
+		// per-partition error returned by the broker itself.
+		for topic, partitions := range r.reqs {
+			perPartition := make([]FetchPartitionResponse, len(partitions))
+			for i, p := range partitions {
+				perPartition[i] = FetchPartitionResponse{
+					Partition: p.Partition,
+					Error:     r.err,
+					Records:   NewRecordReader(),
+				}
+			}
+			ret.Responses[topic] = append(ret.Responses[topic], perPartition...)
+		}
+	}
+
+	return ret, nil
+}
+
+// multiFetch sends a single MultiFetchRequest containing only the partitions
+// in topics to the broker at addr.
+func (c *Client) multiFetch(ctx context.Context, addr net.Addr, req *MultiFetchRequest, topics map[string][]FetchPartitionRequest) (*MultiFetchResponse, error) {
+	topicRequests := make([]fetchAPI.RequestTopic, 0, len(topics))
+
+	for topicName, partitions := range topics {
 		topic := fetchAPI.RequestTopic{
 			Topic:      topicName,
 			Partitions: make([]fetchAPI.RequestPartition, len(partitions)),
@@ -232,18 +354,14 @@ func (c *Client) MultiFetch(ctx context.Context, req *MultiFetchRequest) (*Multi
 			}
 			topic.Partitions[i] = fetchAPI.RequestPartition{
 				Partition:          int32(partition.Partition),
-				CurrentLeaderEpoch: -1,
+				CurrentLeaderEpoch: partition.currentLeaderEpoch(),
 				FetchOffset:        partition.Offset,
 				LogStartOffset:     -1,
 				PartitionMaxBytes:  int32(maxBytes),
 			}
 		}
 
-		topics = append(topics, topic)
-	}
-
-	if len(topics) == 0 {
-		return nil, fmt.Errorf("kafka.(*Client).MultiFetch: %w", protocol.ErrNoTopic)
+		topicRequests = append(topicRequests, topic)
 	}
 
 	timeout := c.timeout(ctx, math.MaxInt64)
@@ -253,17 +371,17 @@ func (c *Client) MultiFetch(ctx context.Context, req *MultiFetchRequest) (*Multi
 		timeout = maxWait
 	}
 
-	m, err := c.roundTrip(ctx, req.Addr, &fetchAPI.Request{
+	m, err := c.roundTrip(ctx, addr, &fetchAPI.Request{
 		ReplicaID:      -1,
 		MaxWaitTime:    milliseconds(timeout),
 		MinBytes:       int32(req.MinBytes),
 		MaxBytes:       int32(req.MaxBytes),
 		IsolationLevel: int8(req.IsolationLevel),
-		Topics:         topics,
+		Topics:         topicRequests,
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("kafka.(*Client).MultiFetch: %w", err)
+		return nil, err
 	}
 
 	res := m.(*fetchAPI.Response)
@@ -277,23 +395,44 @@ func (c *Client) MultiFetch(ctx context.Context, req *MultiFetchRequest) (*Multi
 	}
 
 	for _, t := range res.Topics {
+		requested := make(map[int32]FetchPartitionRequest, len(topics[t.Topic]))
+		for _, p := range topics[t.Topic] {
+			requested[int32(p.Partition)] = p
+		}
+
 		partitions := make([]FetchPartitionResponse, len(t.Partitions))
 
 		for i := range t.Partitions {
 			p := &t.Partitions[i]
 
-			partitions[i] = FetchPartitionResponse{
+			fpr := FetchPartitionResponse{
 				Partition:        int(p.Partition),
 				Error:            makeError(p.ErrorCode, ""),
 				HighWatermark:    p.HighWatermark,
 				LastStableOffset: p.LastStableOffset,
 				LogStartOffset:   p.LogStartOffset,
-				Records:          p.RecordSet.Records,
+				DivergingEpoch: DivergingEpoch{
+					Epoch:     p.DivergingEpoch.Epoch,
+					EndOffset: p.DivergingEpoch.EndOffset,
+				},
+				Records: p.RecordSet.Records,
+			}
+
+			if fpr.Records == nil {
+				fpr.Records = NewRecordReader()
 			}
 
-			if partitions[i].Records == nil {
-				partitions[i].Records = NewRecordReader()
+			if partitionError(p.ErrorCode) != nil {
+				c.invalidateLeader(t.Topic, p.Partition)
+
+				if orig, ok := requested[p.Partition]; ok {
+					if retried, err := c.retryPartitionFetch(ctx, req, t.Topic, orig); err == nil {
+						fpr = retried
+					}
+				}
 			}
+
+			partitions[i] = fpr
 		}
 
 		ret.Responses[t.Topic] = partitions
@@ -302,6 +441,79 @@ func (c *Client) MultiFetch(ctx context.Context, req *MultiFetchRequest) (*Multi
 	return ret, nil
 }
 
+// retryPartitionFetch re-resolves the leader of topic/partition and fetches
+// it once more against the refreshed leader. It is called after a partition
+// in a MultiFetch response comes back with NotLeaderForPartition or
+// LeaderNotAvailable, so that a single stale leader does not surface an
+// avoidable error to the caller.
+func (c *Client) retryPartitionFetch(ctx context.Context, req *MultiFetchRequest, topic string, partition FetchPartitionRequest) (FetchPartitionResponse, error) {
+	meta, err := c.Metadata(ctx, &MetadataRequest{
+		Addr:   req.Addr,
+		Topics: []string{topic},
+	})
+	if err != nil {
+		return FetchPartitionResponse{}, fmt.Errorf("refreshing leader for %s/%d: %w", topic, partition.Partition, err)
+	}
+
+	var addr net.Addr
+	for _, t := range meta.Topics {
+		if t.Name != topic {
+			continue
+		}
+		for _, p := range t.Partitions {
+			if p.ID == partition.Partition {
+				addr = TCP(fmt.Sprintf("%s:%d", p.Leader.Host, p.Leader.Port))
+			}
+		}
+	}
+	if addr == nil {
+		return FetchPartitionResponse{}, fmt.Errorf("no leader found for %s/%d after refresh", topic, partition.Partition)
+	}
+
+	c.leaders().update(topicPartition{topic, int32(partition.Partition)}, addr)
+
+	maxBytes := partition.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = req.MaxBytes
+	}
+
+	res, err := c.Fetch(ctx, &FetchRequest{
+		Addr:               addr,
+		Topic:              topic,
+		Partition:          partition.Partition,
+		Offset:             partition.Offset,
+		CurrentLeaderEpoch: partition.CurrentLeaderEpoch,
+		MinBytes:           req.MinBytes,
+		MaxBytes:           maxBytes,
+		MaxWait:            req.MaxWait,
+		IsolationLevel:     req.IsolationLevel,
+	})
+	if err != nil {
+		return FetchPartitionResponse{}, err
+	}
+
+	return FetchPartitionResponse{
+		Partition:        res.Partition,
+		Error:            res.Error,
+		HighWatermark:    res.HighWatermark,
+		LastStableOffset: res.LastStableOffset,
+		LogStartOffset:   res.LogStartOffset,
+		DivergingEpoch:   res.DivergingEpoch,
+		Records:          res.Records,
+	}, nil
+}
+
+// partitionError reports whether code indicates that the partition's leader
+// information held by the caller is stale and should be refreshed.
+func partitionError(code int16) error {
+	switch err := makeError(code, ""); {
+	case errors.Is(err, NotLeaderForPartition), errors.Is(err, LeaderNotAvailable):
+		return err
+	default:
+		return nil
+	}
+}
+
 func (req *MultiFetchRequest) maxWait() time.Duration {
 	if req.MaxWait > 0 {
 		return req.MaxWait
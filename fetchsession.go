@@ -0,0 +1,290 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	fetchAPI "github.com/segmentio/kafka-go/protocol/fetch"
+)
+
+// FetchSession maintains the incremental fetch session state for a single
+// kafka broker, as described in KIP-227.
+//
+// On the first call to Fetch, the session sends the full set of topic
+// partitions being tracked and lets the broker assign a session id. On
+// subsequent calls, only the partitions whose fetch state changed since the
+// previous call are sent, along with the set of partitions that have been
+// removed since then. This significantly reduces the size of fetch requests
+// for consumers that poll many partitions on the same broker.
+//
+// A FetchSession is not safe for concurrent use by multiple goroutines.
+type FetchSession struct {
+	// Client used to send requests to the broker that owns this session.
+	Client *Client
+
+	// Address of the kafka broker that owns this session.
+	Addr net.Addr
+
+	mutex        sync.Mutex
+	sessionID    int32
+	sessionEpoch int32
+	cached       map[fetchSessionPartitionKey]fetchSessionPartitionState
+}
+
+type fetchSessionPartitionKey struct {
+	topic     string
+	partition int32
+}
+
+type fetchSessionPartitionState struct {
+	fetchOffset        int64
+	logStartOffset     int64
+	currentLeaderEpoch int32
+}
+
+// NewFetchSession constructs a FetchSession which sends its requests to the
+// broker at addr using c.
+func (c *Client) NewFetchSession(addr net.Addr) *FetchSession {
+	return &FetchSession{
+		Client:    c,
+		Addr:      addr,
+		sessionID: -1,
+	}
+}
+
+// FetchSessionRequest represents a single fetch performed through a
+// FetchSession.
+//
+// Requests is indexed by topic name and carries the full set of partitions
+// that the caller wants to be part of the session. Partitions that were part
+// of a previous request but are missing from Requests are forgotten: they
+// are reported to the broker as ForgottenTopicsData and dropped from the
+// session.
+type FetchSessionRequest struct {
+	Requests map[string][]FetchPartitionRequest
+
+	// Size and time limits of the response returned by the broker.
+	MinBytes int64
+	MaxBytes int64
+	MaxWait  time.Duration
+
+	// The isolation level for the request.
+	//
+	// Defaults to ReadUncommitted.
+	IsolationLevel IsolationLevel
+}
+
+// FetchSessionResponse represents the response to a FetchSessionRequest.
+type FetchSessionResponse = MultiFetchResponse
+
+// Fetch sends req to the broker associated with the session, resuming the
+// incremental fetch session if one is already established, or establishing a
+// new one otherwise.
+//
+// If the broker reports that the session is unknown (for example because it
+// expired or the broker restarted) or that the epoch is invalid, the session
+// is transparently reset and the full set of partitions in req is resent.
+func (s *FetchSession) Fetch(ctx context.Context, req *FetchSessionRequest) (*FetchSessionResponse, error) {
+	res, err := s.fetch(ctx, req)
+	if err != nil && (errors.Is(err, FetchSessionIDNotFound) || errors.Is(err, InvalidFetchSessionEpoch)) {
+		s.reset()
+		res, err = s.fetch(ctx, req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kafka.(*FetchSession).Fetch: %w", err)
+	}
+	return res, nil
+}
+
+func (s *FetchSession) fetch(ctx context.Context, req *FetchSessionRequest) (*FetchSessionResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	topics, forgotten := s.buildRequestTopics(req.Requests)
+
+	timeout := s.Client.timeout(ctx, math.MaxInt64)
+	maxWait := req.MaxWait
+	if maxWait <= 0 {
+		maxWait = defaultMaxWait
+	}
+	if maxWait < timeout {
+		timeout = maxWait
+	}
+
+	sessionID := s.sessionID
+	if sessionID < 0 {
+		sessionID = 0
+	}
+
+	m, err := s.Client.roundTrip(ctx, s.Addr, &fetchAPI.Request{
+		ReplicaID:           -1,
+		MaxWaitTime:         milliseconds(timeout),
+		MinBytes:            int32(req.MinBytes),
+		MaxBytes:            int32(req.MaxBytes),
+		IsolationLevel:      int8(req.IsolationLevel),
+		SessionID:           sessionID,
+		SessionEpoch:        s.sessionEpoch,
+		Topics:              topics,
+		ForgottenTopicsData: forgotten,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := m.(*fetchAPI.Response)
+	if res.ErrorCode != 0 {
+		return nil, makeError(res.ErrorCode, "")
+	}
+
+	s.sessionID = res.SessionID
+	s.sessionEpoch++
+
+	ret := &FetchSessionResponse{
+		Throttle: makeDuration(res.ThrottleTimeMs),
+	}
+
+	if res.Topics != nil {
+		ret.Responses = make(map[string][]FetchPartitionResponse, len(res.Topics))
+	}
+
+	for _, t := range res.Topics {
+		partitions := make([]FetchPartitionResponse, len(t.Partitions))
+
+		for i := range t.Partitions {
+			p := &t.Partitions[i]
+
+			partitions[i] = FetchPartitionResponse{
+				Partition:        int(p.Partition),
+				Error:            makeError(p.ErrorCode, ""),
+				HighWatermark:    p.HighWatermark,
+				LastStableOffset: p.LastStableOffset,
+				LogStartOffset:   p.LogStartOffset,
+				DivergingEpoch: DivergingEpoch{
+					Epoch:     p.DivergingEpoch.Epoch,
+					EndOffset: p.DivergingEpoch.EndOffset,
+				},
+				Records: p.RecordSet.Records,
+			}
+
+			if partitions[i].Records == nil {
+				partitions[i].Records = NewRecordReader()
+			}
+		}
+
+		ret.Responses[t.Topic] = partitions
+	}
+
+	return ret, nil
+}
+
+// buildRequestTopics computes the incremental diff between the partitions
+// being requested and the set of partitions that were part of the previous
+// request, returning the partitions to send plus the topics that have been
+// fully or partially forgotten.
+//
+// When no session has been established yet (s.sessionID < 0), the full set
+// of requested partitions is returned and there is nothing to forget.
+func (s *FetchSession) buildRequestTopics(requests map[string][]FetchPartitionRequest) ([]fetchAPI.RequestTopic, []fetchAPI.ForgottenTopic) {
+	newSession := s.sessionID < 0
+	if newSession {
+		s.cached = make(map[fetchSessionPartitionKey]fetchSessionPartitionState)
+	}
+
+	seen := make(map[fetchSessionPartitionKey]bool, len(s.cached))
+	topics := make([]fetchAPI.RequestTopic, 0, len(requests))
+
+	for topicName, partitions := range requests {
+		var changed []fetchAPI.RequestPartition
+
+		for _, partition := range partitions {
+			key := fetchSessionPartitionKey{topicName, int32(partition.Partition)}
+			seen[key] = true
+
+			state := fetchSessionPartitionState{
+				fetchOffset:        partition.Offset,
+				logStartOffset:     -1,
+				currentLeaderEpoch: partition.currentLeaderEpoch(),
+			}
+
+			if !newSession && s.cached[key] == state {
+				continue
+			}
+
+			s.cached[key] = state
+
+			maxBytes := partition.MaxBytes
+			changed = append(changed, fetchAPI.RequestPartition{
+				Partition:          int32(partition.Partition),
+				CurrentLeaderEpoch: state.currentLeaderEpoch,
+				FetchOffset:        state.fetchOffset,
+				LogStartOffset:     state.logStartOffset,
+				PartitionMaxBytes:  int32(maxBytes),
+			})
+		}
+
+		if len(changed) > 0 {
+			topics = append(topics, fetchAPI.RequestTopic{
+				Topic:      topicName,
+				Partitions: changed,
+			})
+		}
+	}
+
+	var forgotten []fetchAPI.ForgottenTopic
+	forgottenByTopic := make(map[string][]int32)
+
+	for key := range s.cached {
+		if seen[key] {
+			continue
+		}
+		forgottenByTopic[key.topic] = append(forgottenByTopic[key.topic], key.partition)
+		delete(s.cached, key)
+	}
+
+	for topic, partitions := range forgottenByTopic {
+		forgotten = append(forgotten, fetchAPI.ForgottenTopic{
+			Topic:      topic,
+			Partitions: partitions,
+		})
+	}
+
+	return topics, forgotten
+}
+
+// reset clears the local session state so that the next call to Fetch starts
+// a brand new session with the broker.
+func (s *FetchSession) reset() {
+	s.sessionID = -1
+	s.sessionEpoch = 0
+	s.cached = nil
+}
+
+// Close tears down the incremental fetch session, letting the broker release
+// any resources associated with it.
+func (s *FetchSession) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.sessionID < 0 {
+		return nil
+	}
+
+	_, err := s.Client.roundTrip(ctx, s.Addr, &fetchAPI.Request{
+		ReplicaID:    -1,
+		MaxWaitTime:  0,
+		SessionID:    s.sessionID,
+		SessionEpoch: -1,
+	})
+
+	s.reset()
+
+	if err != nil {
+		return fmt.Errorf("kafka.(*FetchSession).Close: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package kafka
+
+import "testing"
+
+func TestFetchSessionBuildRequestTopicsNewSession(t *testing.T) {
+	s := &FetchSession{sessionID: -1}
+
+	topics, forgotten := s.buildRequestTopics(map[string][]FetchPartitionRequest{
+		"topic-a": {{Partition: 0, Offset: 0}, {Partition: 1, Offset: 0}},
+	})
+
+	if len(forgotten) != 0 {
+		t.Fatalf("expected no forgotten topics on a new session, got %v", forgotten)
+	}
+	if len(topics) != 1 || len(topics[0].Partitions) != 2 {
+		t.Fatalf("expected the full partition set to be sent, got %v", topics)
+	}
+}
+
+func TestFetchSessionBuildRequestTopicsUnchanged(t *testing.T) {
+	s := &FetchSession{sessionID: -1}
+
+	req := map[string][]FetchPartitionRequest{
+		"topic-a": {{Partition: 0, Offset: 42}},
+	}
+
+	s.buildRequestTopics(req)
+	s.sessionID = 1 // simulate the broker having assigned a session
+
+	topics, forgotten := s.buildRequestTopics(req)
+
+	if len(topics) != 0 {
+		t.Fatalf("expected no partitions to be resent when nothing changed, got %v", topics)
+	}
+	if len(forgotten) != 0 {
+		t.Fatalf("expected nothing forgotten, got %v", forgotten)
+	}
+}
+
+func TestFetchSessionBuildRequestTopicsChangedOffset(t *testing.T) {
+	s := &FetchSession{sessionID: 1, cached: map[fetchSessionPartitionKey]fetchSessionPartitionState{
+		{"topic-a", 0}: {fetchOffset: 42, logStartOffset: -1, currentLeaderEpoch: -1},
+	}}
+
+	topics, _ := s.buildRequestTopics(map[string][]FetchPartitionRequest{
+		"topic-a": {{Partition: 0, Offset: 43}},
+	})
+
+	if len(topics) != 1 || len(topics[0].Partitions) != 1 {
+		t.Fatalf("expected the partition with the changed offset to be resent, got %v", topics)
+	}
+	if got := topics[0].Partitions[0].FetchOffset; got != 43 {
+		t.Fatalf("FetchOffset = %d, want 43", got)
+	}
+}
+
+func TestFetchSessionBuildRequestTopicsForgetsMissingPartitions(t *testing.T) {
+	s := &FetchSession{sessionID: 1, cached: map[fetchSessionPartitionKey]fetchSessionPartitionState{
+		{"topic-a", 0}: {fetchOffset: 0, logStartOffset: -1, currentLeaderEpoch: -1},
+		{"topic-a", 1}: {fetchOffset: 0, logStartOffset: -1, currentLeaderEpoch: -1},
+	}}
+
+	_, forgotten := s.buildRequestTopics(map[string][]FetchPartitionRequest{
+		"topic-a": {{Partition: 0, Offset: 0}},
+	})
+
+	if len(forgotten) != 1 || len(forgotten[0].Partitions) != 1 || forgotten[0].Partitions[0] != 1 {
+		t.Fatalf("expected partition 1 to be forgotten, got %v", forgotten)
+	}
+	if _, ok := s.cached[fetchSessionPartitionKey{"topic-a", 1}]; ok {
+		t.Fatalf("forgotten partition should have been dropped from the cache")
+	}
+}
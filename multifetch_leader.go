@@ -0,0 +1,160 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// topicPartition identifies a single partition of a topic.
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// leaderCache remembers which broker was last known to lead each partition
+// a Client has fetched from, so that MultiFetch does not have to issue a
+// Metadata request for partitions it already has a reasonably fresh answer
+// for.
+type leaderCache struct {
+	mutex   sync.RWMutex
+	leaders map[topicPartition]net.Addr
+}
+
+func newLeaderCache() *leaderCache {
+	return &leaderCache{leaders: make(map[topicPartition]net.Addr)}
+}
+
+func (c *leaderCache) lookup(tp topicPartition) (net.Addr, bool) {
+	c.mutex.RLock()
+	addr, ok := c.leaders[tp]
+	c.mutex.RUnlock()
+	return addr, ok
+}
+
+func (c *leaderCache) update(tp topicPartition, addr net.Addr) {
+	c.mutex.Lock()
+	c.leaders[tp] = addr
+	c.mutex.Unlock()
+}
+
+func (c *leaderCache) invalidate(tp topicPartition) {
+	c.mutex.Lock()
+	delete(c.leaders, tp)
+	c.mutex.Unlock()
+}
+
+// clientLeaderCaches associates a leaderCache with each Client that calls
+// MultiFetch. A sync.Map keyed by the client's address is used instead of a
+// field on Client so that the cache is opt-in and only allocated for callers
+// that actually fan requests out across brokers.
+//
+// The map is keyed by uintptr(unsafe.Pointer(c)), not by c itself: storing
+// the *Client as the key would be a strong reference back to it, and since
+// the map is reachable for the life of the process, that reference would
+// keep every Client that ever called MultiFetch alive forever. Keying on
+// its address instead means the map holds no pointer to c, so c becomes
+// collectible once nothing else references it; a finalizer on c then
+// removes the now-orphaned entry.
+var clientLeaderCaches sync.Map // map[uintptr]*leaderCache
+
+func (c *Client) leaders() *leaderCache {
+	key := uintptr(unsafe.Pointer(c))
+	if v, ok := clientLeaderCaches.Load(key); ok {
+		return v.(*leaderCache)
+	}
+	v, loaded := clientLeaderCaches.LoadOrStore(key, newLeaderCache())
+	if !loaded {
+		runtime.SetFinalizer(c, deleteLeaderCache)
+	}
+	return v.(*leaderCache)
+}
+
+// deleteLeaderCache drops c's entry from clientLeaderCaches. It is registered
+// as c's finalizer so the entry is removed once c becomes unreachable,
+// instead of being retained for the lifetime of the process.
+func deleteLeaderCache(c *Client) {
+	clientLeaderCaches.Delete(uintptr(unsafe.Pointer(c)))
+}
+
+func (c *Client) invalidateLeader(topic string, partition int32) {
+	c.leaders().invalidate(topicPartition{topic, partition})
+}
+
+// groupMultiFetchByLeader resolves the leader broker for every partition in
+// requests and groups the corresponding FetchPartitionRequests by that
+// leader's address. Partitions whose leader is not already cached are
+// resolved through a single Metadata call against req.Addr.
+func (c *Client) groupMultiFetchByLeader(ctx context.Context, req *MultiFetchRequest) (map[net.Addr]map[string][]FetchPartitionRequest, error) {
+	cache := c.leaders()
+
+	grouped := make(map[net.Addr]map[string][]FetchPartitionRequest)
+	var unresolved []string
+
+	assign := func(topic string, partition FetchPartitionRequest, addr net.Addr) {
+		topics := grouped[addr]
+		if topics == nil {
+			topics = make(map[string][]FetchPartitionRequest)
+			grouped[addr] = topics
+		}
+		topics[topic] = append(topics[topic], partition)
+	}
+
+	pending := make(map[string][]FetchPartitionRequest, len(req.Requests))
+
+	for topic, partitions := range req.Requests {
+		for _, partition := range partitions {
+			addr, ok := cache.lookup(topicPartition{topic, int32(partition.Partition)})
+			if ok {
+				assign(topic, partition, addr)
+				continue
+			}
+			pending[topic] = append(pending[topic], partition)
+		}
+	}
+
+	if len(pending) == 0 {
+		return grouped, nil
+	}
+
+	for topic := range pending {
+		unresolved = append(unresolved, topic)
+	}
+
+	meta, err := c.Metadata(ctx, &MetadataRequest{
+		Addr:   req.Addr,
+		Topics: unresolved,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("resolving partition leaders: %w", err)
+	}
+
+	leaderAddr := make(map[topicPartition]net.Addr)
+	for _, t := range meta.Topics {
+		for _, p := range t.Partitions {
+			leaderAddr[topicPartition{t.Name, int32(p.ID)}] = TCP(fmt.Sprintf("%s:%d", p.Leader.Host, p.Leader.Port))
+		}
+	}
+
+	for topic, partitions := range pending {
+		for _, partition := range partitions {
+			tp := topicPartition{topic, int32(partition.Partition)}
+			addr, ok := leaderAddr[tp]
+			if !ok {
+				// The leader could not be determined (e.g. the partition
+				// does not exist, or is between leader elections); fall
+				// back to the bootstrap address and let the broker return
+				// the appropriate per-partition error.
+				addr = req.Addr
+			} else {
+				cache.update(tp, addr)
+			}
+			assign(topic, partition, addr)
+		}
+	}
+
+	return grouped, nil
+}
@@ -0,0 +1,43 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLeaderCacheUpdateLookupInvalidate(t *testing.T) {
+	c := newLeaderCache()
+	tp := topicPartition{"topic-a", 0}
+
+	if _, ok := c.lookup(tp); ok {
+		t.Fatalf("expected no leader cached yet")
+	}
+
+	c.update(tp, TCP("broker-1:9092"))
+
+	addr, ok := c.lookup(tp)
+	if !ok || addr.String() != "broker-1:9092" {
+		t.Fatalf("lookup() = %v, %v; want broker-1:9092, true", addr, ok)
+	}
+
+	c.invalidate(tp)
+
+	if _, ok := c.lookup(tp); ok {
+		t.Fatalf("expected the leader to be gone after invalidate")
+	}
+}
+
+func TestPartitionErrorOnlyFlagsStaleLeaderCodes(t *testing.T) {
+	if err := partitionError(0); err != nil {
+		t.Fatalf("expected no error for code 0, got %v", err)
+	}
+
+	// Kafka protocol error codes 5 and 6, LeaderNotAvailable and
+	// NotLeaderForPartition respectively; both should be flagged as stale.
+	if err := partitionError(5); !errors.Is(err, LeaderNotAvailable) {
+		t.Fatalf("expected LeaderNotAvailable to be flagged as stale, got %v", err)
+	}
+	if err := partitionError(6); !errors.Is(err, NotLeaderForPartition) {
+		t.Fatalf("expected NotLeaderForPartition to be flagged as stale, got %v", err)
+	}
+}
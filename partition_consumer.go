@@ -0,0 +1,345 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PartitionConsumer continuously fetches records from a single (topic,
+// partition) and delivers them one message at a time over Messages, or
+// grouped into batches over Batches when a BatchPolicy is attached. It is
+// modeled on the partition-consumer pattern found in other kafka client
+// libraries: callers compose many PartitionConsumers (one per partition) the
+// same way they compose MessageIters with NewMultiIter.
+type PartitionConsumer struct {
+	client    *Client
+	addr      net.Addr
+	topic     string
+	partition int
+	minBytes  int64
+	maxBytes  int64
+	maxWait   time.Duration
+	backoff   BackoffPolicy
+	batch     BatchPolicy
+	offsets   OffsetTracker
+
+	messages chan Message
+	batches  chan MessageBatch
+	errors   chan error
+	done     chan struct{}
+	closeErr sync.Once
+
+	highWaterMark int64 // accessed atomically
+}
+
+// PartitionConsumerConfig configures a PartitionConsumer.
+type PartitionConsumerConfig struct {
+	// Address of the broker leading the partition.
+	Addr net.Addr
+
+	Topic     string
+	Partition int
+
+	// Offset to start consuming from.
+	Offset int64
+
+	MinBytes int64
+	MaxBytes int64
+	MaxWait  time.Duration
+
+	// Backoff controls how long the consumer waits before retrying a fetch
+	// that returned no records.
+	//
+	// Defaults to an exponential backoff between 100ms and 1s.
+	Backoff BackoffPolicy
+
+	// Batch groups messages before they are delivered on Batches. If nil,
+	// Batches is never sent to and callers should read from Messages
+	// instead.
+	Batch BatchPolicy
+
+	// OffsetTracker, if set, is notified through MarkOffset every time a
+	// message (or, with a BatchPolicy attached, a batch) has been delivered
+	// to the caller, so that the offset can be recorded as acknowledged.
+	OffsetTracker OffsetTracker
+}
+
+// BackoffPolicy computes how long to wait before the next fetch attempt,
+// given the number of consecutive attempts that returned no records.
+type BackoffPolicy interface {
+	Backoff(attempt int) time.Duration
+}
+
+// BackoffFunc is a BackoffPolicy implemented by a plain function.
+type BackoffFunc func(attempt int) time.Duration
+
+// Backoff implements the BackoffPolicy interface.
+func (f BackoffFunc) Backoff(attempt int) time.Duration { return f(attempt) }
+
+func defaultBackoff(attempt int) time.Duration {
+	d := 100 * time.Millisecond
+	for i := 0; i < attempt && d < time.Second; i++ {
+		d *= 2
+	}
+	if d > time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// NewPartitionConsumer starts a background goroutine that fetches records
+// from config.Topic/config.Partition through client, starting at
+// config.Offset, until the returned PartitionConsumer is closed.
+func NewPartitionConsumer(client *Client, config PartitionConsumerConfig) *PartitionConsumer {
+	backoff := config.Backoff
+	if backoff == nil {
+		backoff = BackoffFunc(defaultBackoff)
+	}
+
+	pc := &PartitionConsumer{
+		client:    client,
+		addr:      config.Addr,
+		topic:     config.Topic,
+		partition: config.Partition,
+		minBytes:  config.MinBytes,
+		maxBytes:  config.MaxBytes,
+		maxWait:   config.MaxWait,
+		backoff:   backoff,
+		batch:     config.Batch,
+		offsets:   config.OffsetTracker,
+		messages:  make(chan Message),
+		errors:    make(chan error),
+		done:      make(chan struct{}),
+	}
+
+	if pc.batch != nil {
+		pc.batches = make(chan MessageBatch)
+	}
+
+	go pc.run(config.Offset)
+
+	return pc
+}
+
+// Messages returns the channel that decoded messages are delivered on. It is
+// only populated when the PartitionConsumer was created without a
+// BatchPolicy; otherwise read from Batches instead.
+func (pc *PartitionConsumer) Messages() <-chan Message { return pc.messages }
+
+// Batches returns the channel that MessageBatch values are delivered on. It
+// is nil when the PartitionConsumer was created without a BatchPolicy.
+func (pc *PartitionConsumer) Batches() <-chan MessageBatch { return pc.batches }
+
+// Errors returns the channel that fetch and decode errors are delivered on.
+func (pc *PartitionConsumer) Errors() <-chan error { return pc.errors }
+
+// HighWaterMarkOffset returns the last high watermark offset reported by the
+// broker for this partition.
+func (pc *PartitionConsumer) HighWaterMarkOffset() int64 {
+	return atomic.LoadInt64(&pc.highWaterMark)
+}
+
+// Close stops the PartitionConsumer's background goroutine. It is safe to
+// call Close more than once, and from multiple goroutines.
+func (pc *PartitionConsumer) Close() error {
+	pc.closeErr.Do(func() { close(pc.done) })
+	return nil
+}
+
+func (pc *PartitionConsumer) run(offset int64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defer close(pc.messages)
+	if pc.batches != nil {
+		defer close(pc.batches)
+	}
+	defer close(pc.errors)
+
+	go func() {
+		<-pc.done
+		cancel()
+	}()
+
+	var builder *batchBuilder
+	if pc.batch != nil {
+		builder = newBatchBuilder(pc.topic, pc.partition, pc.batch)
+	}
+
+	attempt := 0
+
+	for {
+		res, err := pc.client.Fetch(ctx, &FetchRequest{
+			Addr:      pc.addr,
+			Topic:     pc.topic,
+			Partition: pc.partition,
+			Offset:    offset,
+			MinBytes:  pc.minBytes,
+			MaxBytes:  pc.maxBytes,
+			MaxWait:   pc.maxWait,
+		})
+
+		var count int
+		var lastOffset int64
+		ok := true
+
+		switch {
+		case err != nil:
+			ok = pc.emitError(ctx, err)
+		case res.Error != nil:
+			ok = pc.emitError(ctx, res.Error)
+		default:
+			atomic.StoreInt64(&pc.highWaterMark, res.HighWatermark)
+			count, lastOffset, ok = pc.drain(ctx, offset, res, builder)
+			res.Records.Close()
+		}
+
+		if !ok {
+			return
+		}
+		if count > 0 {
+			offset = lastOffset + 1
+			attempt = 0
+			continue
+		}
+
+		// Nothing new arrived on this attempt: give a time-triggered
+		// BatchPolicy a chance to flush whatever the batch already holds
+		// before waiting out the backoff.
+		if builder != nil {
+			if batch, ready := builder.flush(); ready {
+				if !pc.emitBatch(ctx, batch) {
+					return
+				}
+			}
+		}
+
+		attempt++
+		select {
+		case <-time.After(pc.backoff.Backoff(attempt)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain reads every record out of res.Records, delivering each as a Message
+// (or feeding it to builder when a BatchPolicy is attached). Records at an
+// offset before requested are skipped, since brokers may return a record
+// batch that starts earlier than the requested offset. It returns the
+// number of records delivered, the offset of the last one, and false if the
+// consumer was closed while delivering.
+func (pc *PartitionConsumer) drain(ctx context.Context, requested int64, res *FetchResponse, builder *batchBuilder) (count int, lastOffset int64, ok bool) {
+	lastOffset = requested - 1
+
+	for {
+		rec, err := res.Records.ReadRecord()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				if !pc.emitError(ctx, err) {
+					return count, lastOffset, false
+				}
+			}
+			return count, lastOffset, true
+		}
+
+		if rec.Offset < requested {
+			continue
+		}
+
+		msg, err := decodeMessage(pc.topic, pc.partition, atomic.LoadInt64(&pc.highWaterMark), rec)
+		if err != nil {
+			if !pc.emitError(ctx, err) {
+				return count, lastOffset, false
+			}
+			continue
+		}
+
+		count++
+		lastOffset = msg.Offset
+
+		if builder == nil {
+			select {
+			case pc.messages <- msg:
+				pc.markOffset(msg.Offset)
+			case <-ctx.Done():
+				return count, lastOffset, false
+			}
+			continue
+		}
+
+		if batch, ready := builder.add(msg); ready {
+			if !pc.emitBatch(ctx, batch) {
+				return count, lastOffset, false
+			}
+		}
+	}
+}
+
+func (pc *PartitionConsumer) emitBatch(ctx context.Context, batch MessageBatch) bool {
+	select {
+	case pc.batches <- batch:
+		pc.markOffset(batch.LastOffset())
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// markOffset notifies the configured OffsetTracker, if any, that offset has
+// been delivered to the caller and can be considered acknowledged.
+func (pc *PartitionConsumer) markOffset(offset int64) {
+	if pc.offsets != nil {
+		pc.offsets.MarkOffset(pc.topic, pc.partition, offset, "")
+	}
+}
+
+func (pc *PartitionConsumer) emitError(ctx context.Context, err error) bool {
+	select {
+	case pc.errors <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// decodeMessage converts a Record read off the wire into the Message type
+// exposed to callers.
+func decodeMessage(topic string, partition int, highWaterMark int64, rec *Record) (Message, error) {
+	msg := Message{
+		Topic:         topic,
+		Partition:     partition,
+		Offset:        rec.Offset,
+		HighWaterMark: highWaterMark,
+		Time:          rec.Time,
+	}
+
+	if rec.Key != nil {
+		defer rec.Key.Close()
+		key, err := io.ReadAll(rec.Key)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.Key = key
+	}
+
+	if rec.Value != nil {
+		defer rec.Value.Close()
+		value, err := io.ReadAll(rec.Value)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.Value = value
+	}
+
+	for _, h := range rec.Headers {
+		msg.Headers = append(msg.Headers, h)
+	}
+
+	return msg, nil
+}
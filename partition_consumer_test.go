@@ -0,0 +1,114 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDefaultBackoffGrowsAndCaps(t *testing.T) {
+	if got := defaultBackoff(0); got != 100*time.Millisecond {
+		t.Fatalf("defaultBackoff(0) = %v, want 100ms", got)
+	}
+	if got := defaultBackoff(1); got != 200*time.Millisecond {
+		t.Fatalf("defaultBackoff(1) = %v, want 200ms", got)
+	}
+	if got := defaultBackoff(20); got != time.Second {
+		t.Fatalf("defaultBackoff(20) = %v, want capped at 1s", got)
+	}
+}
+
+func TestPartitionConsumerEmitBatchMarksOffset(t *testing.T) {
+	var marked []int64
+	tracker := OffsetTrackerFunc(func(topic string, partition int, offset int64, metadata string) {
+		marked = append(marked, offset)
+	})
+
+	pc := &PartitionConsumer{
+		topic:     "topic-a",
+		partition: 0,
+		offsets:   tracker,
+		batches:   make(chan MessageBatch, 1),
+	}
+
+	batch := MessageBatch{
+		Topic:     "topic-a",
+		Partition: 0,
+		Messages:  []Message{{Offset: 5}, {Offset: 6}},
+	}
+
+	if !pc.emitBatch(context.Background(), batch) {
+		t.Fatalf("emitBatch returned false")
+	}
+
+	select {
+	case got := <-pc.batches:
+		if got.LastOffset() != 6 {
+			t.Fatalf("unexpected batch delivered: %+v", got)
+		}
+	default:
+		t.Fatalf("expected a batch to be queued on pc.batches")
+	}
+
+	if len(marked) != 1 || marked[0] != 6 {
+		t.Fatalf("expected MarkOffset(6) to be called once, got %v", marked)
+	}
+}
+
+func TestPartitionConsumerEmitBatchAbortsOnContextDone(t *testing.T) {
+	pc := &PartitionConsumer{batches: make(chan MessageBatch)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if pc.emitBatch(ctx, MessageBatch{Messages: []Message{{Offset: 1}}}) {
+		t.Fatalf("expected emitBatch to abort once the context is done")
+	}
+}
+
+func TestPartitionConsumerEmitError(t *testing.T) {
+	pc := &PartitionConsumer{errors: make(chan error, 1)}
+	wantErr := errors.New("boom")
+
+	if !pc.emitError(context.Background(), wantErr) {
+		t.Fatalf("emitError returned false")
+	}
+
+	select {
+	case got := <-pc.errors:
+		if got != wantErr {
+			t.Fatalf("emitError delivered %v, want %v", got, wantErr)
+		}
+	default:
+		t.Fatalf("expected an error to be queued on pc.errors")
+	}
+}
+
+func TestPartitionConsumerMarkOffsetWithoutTracker(t *testing.T) {
+	pc := &PartitionConsumer{}
+	pc.markOffset(10) // must not panic when no OffsetTracker is configured
+}
+
+func TestPartitionConsumerCloseIsIdempotent(t *testing.T) {
+	pc := &PartitionConsumer{done: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pc.Close(); err != nil {
+				t.Errorf("Close() returned an error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-pc.done:
+	default:
+		t.Fatalf("expected done to be closed")
+	}
+}